@@ -0,0 +1,37 @@
+package bayeux
+
+import (
+	"errors"
+	"time"
+)
+
+// handleAdvice applies a single server advice field, as received on a
+// /meta/handshake or /meta/connect reply (or any other response, since the
+// spec allows advice on any message). It is only ever invoked from
+// adviceLoop, so concurrent advices are always applied in order.
+func (c *Client) handleAdvice(adv *advice) {
+	if adv == nil {
+		return
+	}
+
+	if adv.Interval > 0 {
+		c.mtx.Lock()
+		c.interval = time.Duration(adv.Interval) * time.Millisecond
+		c.mtx.Unlock()
+	}
+
+	if adv.Timeout > 0 {
+		c.mtx.Lock()
+		c.timeout = time.Duration(adv.Timeout) * time.Millisecond
+		c.mtx.Unlock()
+	}
+
+	switch adv.Reconnect {
+	case "handshake":
+		c.reconnectWithBackoff(errors.New("bayeux: server advised reconnect=handshake"))
+	case "none":
+		err := errors.New("bayeux: server advised reconnect=none")
+		c.emit(Event{Type: EventFailed, Err: err})
+		c.tomb.Kill(err)
+	}
+}
@@ -0,0 +1,172 @@
+package bayeux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// defaultBatchWindow is how long send() waits for other concurrent callers
+// to join a batch before it is flushed as a single HTTP round trip. The
+// Bayeux protocol's request envelope is already an array of messages, so a
+// CometD server expects batched clients to behave this way.
+const defaultBatchWindow = 5 * time.Millisecond
+
+// queuedRequest is a single caller's request waiting to be folded into the
+// next batch, along with the channel it blocks on for its reply.
+type queuedRequest struct {
+	req   *request
+	reply chan sendOutcome
+}
+
+type sendOutcome struct {
+	msg *metaMessage
+	err error
+}
+
+// SetBatchWindow configures how long concurrent requests (Subscribe,
+// Unsubscribe, publishes, ...) are coalesced into a single HTTP round trip
+// before being flushed. The default is a few milliseconds.
+func (c *Client) SetBatchWindow(window time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.batchWindow = window
+}
+
+// send assigns req a unique id, queues it to be sent as part of the next
+// batch, and blocks until that batch's reply for req arrives.
+func (c *Client) send(req *request) (*metaMessage, error) {
+	req.Id = fmt.Sprintf("%d", atomic.AddUint64(&c.nextRequestID, 1))
+
+	qr := &queuedRequest{req: req, reply: make(chan sendOutcome, 1)}
+	c.queue <- qr
+
+	outcome := <-qr.reply
+	return outcome.msg, outcome.err
+}
+
+// batchLoop coalesces every request enqueued within a single batchWindow
+// into one transport round trip, then demultiplexes the replies back to
+// their caller by matching `id`. It runs for the lifetime of the client.
+func (c *Client) batchLoop() {
+	var batch []*queuedRequest
+	var timerC <-chan time.Time
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		c.flushBatch(batch)
+		batch = nil
+		timerC = nil
+	}
+
+	for {
+		select {
+		case qr, ok := <-c.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, qr)
+			if timerC == nil {
+				c.mtx.RLock()
+				window := c.batchWindow
+				c.mtx.RUnlock()
+				if window <= 0 {
+					window = defaultBatchWindow
+				}
+				timerC = time.After(window)
+			}
+		case <-timerC:
+			flush()
+		}
+	}
+}
+
+// flushBatch sends every request in batch as a single transport round trip,
+// running extensions over each request and reply, and routes each reply back
+// to its originating queuedRequest by matching `id`. Any message id that
+// isn't a reply to this batch (server push) is handed to the usual
+// c.messages fan-out instead.
+func (c *Client) flushBatch(batch []*queuedRequest) {
+	c.mtx.RLock()
+	timeout := c.timeout
+	extensions := c.extensions
+	c.mtx.RUnlock()
+
+	reqs := make([]*request, len(batch))
+	pending := make(map[string]*queuedRequest, len(batch))
+	for i, qr := range batch {
+		reqs[i] = qr.req
+		pending[qr.req.Id] = qr
+
+		out := &OutgoingRequest{
+			Channel:      qr.req.Channel,
+			Subscription: qr.req.Subscription,
+			ClientId:     qr.req.ClientId,
+			Data:         qr.req.Data,
+			Ext:          qr.req.Extension,
+		}
+		for _, ext := range extensions {
+			ext.Outgoing(qr.req.Channel, out)
+		}
+		qr.req.Extension = out.Ext
+	}
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	messages, err := c.transport.send(ctx, reqs)
+	if err != nil {
+		for _, qr := range batch {
+			qr.reply <- sendOutcome{err: err}
+		}
+		return
+	}
+
+	for i := range messages {
+		msg := &messages[i]
+
+		var msgErr error
+		in := IncomingMessage{
+			Channel:    msg.Channel,
+			Data:       msg.Data,
+			Successful: msg.Successful,
+			Error:      msg.Error,
+		}
+		for _, ext := range extensions {
+			if err := ext.Incoming(msg.Channel, in); err != nil {
+				msgErr = err
+			}
+		}
+
+		qr, isReply := pending[msg.Id]
+		if isReply {
+			delete(pending, msg.Id)
+		} else {
+			c.messages <- &msg.Message
+		}
+
+		if msg.Advice != nil {
+			c.advice <- msg.Advice
+		}
+
+		if isReply {
+			qr.reply <- sendOutcome{msg: msg, err: msgErr}
+		} else if msgErr != nil {
+			log.Printf("[WRN] Bayeux extension rejected message on %q: %s", msg.Channel, msgErr)
+		}
+	}
+
+	for _, qr := range pending {
+		qr.reply <- sendOutcome{err: errors.New("bayeux: no reply received for request")}
+	}
+}
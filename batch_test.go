@@ -0,0 +1,72 @@
+package bayeux
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestBatchDemuxesRepliesById drives several concurrent c.send calls on the
+// same channel through one Client, and checks that each caller gets back the
+// reply that actually matches its own request id, even though every request
+// shares a channel name and the fake transport deliberately reorders its
+// replies.
+func TestBatchDemuxesRepliesById(t *testing.T) {
+	transport := newFakeTransport(func(reqs []*request) []metaMessage {
+		replies := make([]metaMessage, len(reqs))
+		for i, req := range reqs {
+			reply := metaReply(req)
+			reply.Data = req.Data
+			replies[len(reqs)-1-i] = reply // deliberately out of order
+		}
+		return replies
+	})
+
+	c := NewClient("http://test.invalid/bayeux", nil, transport)
+	c.SetBatchWindow(50 * time.Millisecond)
+	defer c.Close()
+
+	const n = 8
+	var wg sync.WaitGroup
+	ready := make(chan struct{})
+	results := make([]struct {
+		want int
+		got  int
+		err  error
+	}, n)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-ready
+			rsp, err := c.send(&request{
+				Channel: "/test/echo",
+				Data:    marshal(map[string]int{"n": i}),
+			})
+			results[i].want = i
+			results[i].err = err
+			if err == nil {
+				var payload map[string]int
+				json.Unmarshal(rsp.Data, &payload)
+				results[i].got = payload["n"]
+			}
+		}(i)
+	}
+	close(ready)
+	wg.Wait()
+
+	for _, r := range results {
+		if r.err != nil {
+			t.Fatalf("send: %s", r.err)
+		}
+		if r.got != r.want {
+			t.Fatalf("got reply for n=%d, want n=%d: batch demux matched the wrong reply", r.got, r.want)
+		}
+	}
+
+	if calls := transport.callCount(); calls != 1 {
+		t.Fatalf("expected all %d concurrent sends to be coalesced into one transport round trip, got %d", n, calls)
+	}
+}
@@ -1,17 +1,12 @@
 package bayeux
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"io/ioutil"
-	"log"
 	"net/http"
 	"sync"
 	"time"
 
-	"github.com/obeattie/ohmyglob"
 	"gopkg.in/tomb.v2"
 )
 
@@ -22,15 +17,25 @@ const (
 
 // Client allows connecting to a Bayeux server and subscribing to channels.
 type Client struct {
-	mtx           sync.RWMutex
-	url           string
-	clientId      string
-	tomb          *tomb.Tomb
-	subscriptions map[string]interface{}
-	messages      chan *Message
-	connected     bool
-	http          *http.Client
-	interval      time.Duration
+	mtx             sync.RWMutex
+	connectMtx      sync.Mutex
+	url             string
+	clientId        string
+	tomb            *tomb.Tomb
+	subscriptions   map[string]*subscriptionSet
+	messages        chan *Message
+	connected       bool
+	transport       Transport
+	interval        time.Duration
+	timeout         time.Duration
+	advice          chan *advice
+	reconnectPolicy ReconnectPolicy
+	events          chan<- Event
+	extensions      []Extension
+	nextRequestID   uint64
+	batchWindow     time.Duration
+	queue           chan *queuedRequest
+	reconnecting    int32
 }
 
 // Message is the type delivered to subscribers.
@@ -42,11 +47,6 @@ type Message struct {
 	Extension interface{}     `json:"ext,omitempty"`
 }
 
-type subscription struct {
-	glob ohmyglob.Glob
-	out  chan<- *Message
-}
-
 type request struct {
 	Channel                  string          `json:"channel"`
 	Data                     json.RawMessage `json:"data,omitempty"`
@@ -80,18 +80,24 @@ type metaMessage struct {
 }
 
 // NewClient initialises a new Bayeux client. By default `http.DefaultClient`
-// is used for HTTP connections.
-func NewClient(url string, httpClient *http.Client) *Client {
-	if httpClient == nil {
-		httpClient = http.DefaultClient
+// is used for HTTP connections over the long-polling transport. Pass a
+// non-nil transport (e.g. a WebSocketTransport) to use a different Bayeux
+// connection type.
+func NewClient(url string, httpClient *http.Client, transport Transport) *Client {
+	if transport == nil {
+		transport = NewLongPollingTransport(url, httpClient)
 	}
 
-	return &Client{
+	c := &Client{
 		url:           url,
-		http:          httpClient,
+		transport:     transport,
 		messages:      make(chan *Message, 100),
-		subscriptions: make(map[string]interface{}),
+		subscriptions: make(map[string]*subscriptionSet),
+		advice:        make(chan *advice, 16),
+		queue:         make(chan *queuedRequest),
 	}
+	go c.batchLoop()
+	return c
 }
 
 // Connect performs a handshake with the server and will repeatedly initiate a
@@ -100,60 +106,48 @@ func (c *Client) Connect() error {
 	return c.ensureConnected()
 }
 
-// Close notifies the Bayeux server of the intent to disconnect and terminates
-// the background polling loop.
-func (c *Client) Close() error {
+// SetReconnectPolicy configures the backoff used when the client has to
+// re-establish its session after a connection failure. It is safe to call at
+// any time, including while connected.
+func (c *Client) SetReconnectPolicy(policy ReconnectPolicy) {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
-	c.tomb.Killf("Close")
-	c.connected = false
-	return c.disconnect()
-}
-
-func (c *Client) Unsubscribe(pattern string) error {
-	rsp, err := c.send(&request{
-		Channel:      "/meta/unsubscribe",
-		ClientId:     c.clientId,
-		Subscription: pattern,
-	})
-	if err != nil {
-		return err
-	}
-	if !rsp.Successful {
-		return errors.New(rsp.Error)
-	}
-
-	c.doForgetSubscription(pattern)
-
-	return nil
+	c.reconnectPolicy = policy
 }
 
-// ForgetSubscription ensure to remove subscription object from
-// the c.subscriptions slices. In back-side, the channel out
-// inside it should have been closed before
-// we search for 1st occurence of pattern
-func (c *Client) doForgetSubscription(pattern string) {
+// SetEvents registers a channel on which the client reports lifecycle events
+// (connected, reconnecting, reconnected, failed). Sends are non-blocking, so
+// a slow or undrained consumer never stalls the client.
+func (c *Client) SetEvents(events chan<- Event) {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
-
-	c.subscriptions[pattern] = ""
+	c.events = events
 }
 
-// Subscribe is like `SubscribeExt` with a blank `ext` part.
-func (c *Client) Subscribe(pattern string, out chan<- *Message) error {
-	return c.SubscribeExt(pattern, out, nil)
-}
+// Close notifies the Bayeux server of the intent to disconnect and terminates
+// the background polling and batching loops. It is safe to call even if
+// Connect/Subscribe was never called.
+func (c *Client) Close() error {
+	c.mtx.Lock()
+	if c.tomb != nil {
+		c.tomb.Killf("Close")
+	}
+	c.connected = false
+	c.mtx.Unlock()
 
-// SubscribeExt creates a new subscription on the Bayeux server. Messages for
-// the subscription will be delivered on the given channel `out`. If the client
-// has not performed a handshake already, it will do so first.
-func (c *Client) SubscribeExt(pattern string, out chan<- *Message, ext interface{}) error {
-	if err := c.ensureConnected(); err != nil {
-		return err
+	err := c.disconnect()
+	close(c.queue)
+
+	if closeErr := c.transport.close(); err == nil {
+		err = closeErr
 	}
-	return c.subscribe(pattern, out, ext)
+	return err
 }
 
+// ensureConnected performs the client's first handshake if it hasn't
+// happened yet. connectMtx (rather than c.mtx) serializes concurrent
+// callers, because handshake() blocks on send(), which itself needs c.mtx to
+// be free for batchLoop to read c.batchWindow and flush the request.
 func (c *Client) ensureConnected() error {
 	c.mtx.RLock()
 	connected := c.connected
@@ -163,38 +157,58 @@ func (c *Client) ensureConnected() error {
 		return nil
 	}
 
-	c.mtx.Lock()
-	defer c.mtx.Unlock()
-	if c.connected {
+	c.connectMtx.Lock()
+	defer c.connectMtx.Unlock()
+
+	c.mtx.RLock()
+	connected = c.connected
+	c.mtx.RUnlock()
+	if connected {
 		return nil
 	}
+
 	err := c.handshake()
-	if err == nil {
-		c.connected = err == nil
-		c.tomb = &tomb.Tomb{}
-		c.tomb.Go(c.worker)
+	if err != nil {
+		return err
 	}
-	return err
+
+	c.mtx.Lock()
+	c.connected = true
+	c.tomb = &tomb.Tomb{}
+	c.tomb.Go(c.worker)
+	c.mtx.Unlock()
+
+	c.emit(Event{Type: EventConnected})
+	return nil
 }
 
 func (c *Client) worker() error {
+	c.tomb.Go(func() error {
+		return c.transport.loop(c)
+	})
+	c.tomb.Go(c.adviceLoop)
+
 	for {
 		select {
 		case msg := <-c.messages:
-			for _, sub := range c.subscriptions {
-				if s, subOpened := sub.(subscription); subOpened {
-					if s.glob.MatchString(msg.Channel) {
-						s.out <- msg
-					}
-				}
-			}
+			c.dispatch(msg)
+		case <-c.tomb.Dying():
+			c.failAll(c.tomb.Err())
+			return nil
+		}
+	}
+}
+
+// adviceLoop applies advice from the server one at a time, off the hot path
+// of send(), so that an interval change, a timeout change, and a reconnect
+// triggered by concurrent requests can never race each other.
+func (c *Client) adviceLoop() error {
+	for {
+		select {
+		case adv := <-c.advice:
+			c.handleAdvice(adv)
 		case <-c.tomb.Dying():
 			return nil
-		case <-time.After(c.interval):
-			_, err := c.connect()
-			if err != nil {
-				log.Printf("[WRN] Bayeux connect failed: %s", err)
-			}
 		}
 	}
 }
@@ -204,7 +218,7 @@ func (c *Client) handshake() error {
 		Channel:                  "/meta/handshake",
 		Version:                  VERSION,
 		MinimumVersion:           MINIMUM_VERSION,
-		SupportedConnectionTypes: []string{"long-polling"},
+		SupportedConnectionTypes: []string{c.transport.connectionType()},
 	})
 	if err != nil {
 		return err
@@ -212,43 +226,31 @@ func (c *Client) handshake() error {
 	if !rsp.Successful {
 		return errors.New(rsp.Error)
 	}
+	c.mtx.Lock()
 	c.clientId = rsp.ClientId
+	c.mtx.Unlock()
 	return nil
 }
 
 func (c *Client) connect() (*metaMessage, error) {
 	rsp, err := c.send(&request{
 		Channel:        "/meta/connect",
-		ClientId:       c.clientId,
-		ConnectionType: "long-polling",
-	})
-	return rsp, err
-}
-
-func (c *Client) disconnect() error {
-	rsp, err := c.send(&request{
-		Channel:  "/meta/disconnect",
-		ClientId: c.clientId,
+		ClientId:       c.clientID(),
+		ConnectionType: c.transport.connectionType(),
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if !rsp.Successful {
-		return errors.New(rsp.Error)
+		return rsp, errors.New(rsp.Error)
 	}
-	return nil
+	return rsp, nil
 }
 
-func (c *Client) subscribe(pattern string, out chan<- *Message, ext interface{}) error {
-	glob, err := ohmyglob.Compile(pattern, nil)
-	if err != nil {
-		return fmt.Errorf("Invalid pattern: %s", err)
-	}
+func (c *Client) disconnect() error {
 	rsp, err := c.send(&request{
-		Channel:      "/meta/subscribe",
-		ClientId:     c.clientId,
-		Subscription: pattern,
-		Extension:    ext,
+		Channel:  "/meta/disconnect",
+		ClientId: c.clientID(),
 	})
 	if err != nil {
 		return err
@@ -256,55 +258,23 @@ func (c *Client) subscribe(pattern string, out chan<- *Message, ext interface{})
 	if !rsp.Successful {
 		return errors.New(rsp.Error)
 	}
-
-	c.mtx.Lock()
-	defer c.mtx.Unlock()
-	c.subscriptions[glob.String()] = subscription{
-		glob: glob,
-		out:  out,
-	}
-
 	return nil
 }
 
-func (c *Client) send(req *request) (*metaMessage, error) {
-	data, err := json.Marshal([]*request{req})
-	if err != nil {
-		return nil, err
-	}
-	buffer := bytes.NewBuffer(data)
-	rsp, err := c.http.Post(c.url, "application/json", buffer)
-	if err != nil {
-		return nil, err
-	}
-
-	if rsp.StatusCode != 200 {
-		return nil, fmt.Errorf("HTTP Status %d", rsp.StatusCode)
-	}
-
-	data, err = ioutil.ReadAll(rsp.Body)
-	if err != nil {
-		return nil, err
-	}
-	defer rsp.Body.Close()
-
-	var messages []metaMessage
-	var reply *metaMessage
-	if err = json.Unmarshal(data, &messages); err != nil {
-		return nil, err
-	}
-
-	// 1. Check advice: Update interval
-	// 2. Check advice: Reconnect "handshake" => reconnect
-	// 3. Handle messages to just-created subscriptions
-
-	for _, msg := range messages {
-		if req.Channel == msg.Channel {
-			reply = &msg
-		} else {
-			c.messages <- &msg.Message
-		}
-	}
+// clientID returns the current Bayeux clientId. handshake writes it, and
+// reconnectWithBackoff resets it, from whichever goroutine is driving the
+// transport loop at the time, so every read needs to go through c.mtx too.
+func (c *Client) clientID() string {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return c.clientId
+}
 
-	return reply, err
+// pollInterval returns the long-poll interval. handleAdvice updates it from
+// adviceLoop while a transport's own loop goroutine reads it, so the read
+// needs to go through c.mtx too.
+func (c *Client) pollInterval() time.Duration {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return c.interval
 }
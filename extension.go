@@ -0,0 +1,48 @@
+package bayeux
+
+import "encoding/json"
+
+// OutgoingRequest is the exported, mutable view of an outgoing Bayeux
+// request that Extension.Outgoing may inspect and attach an `ext` payload
+// to. It exists so Extension, an exported interface, can be implemented
+// outside this package without access to its internal wire-format types.
+type OutgoingRequest struct {
+	Channel      string
+	Subscription string
+	ClientId     string
+	Data         json.RawMessage
+	Ext          interface{}
+}
+
+// IncomingMessage is the exported, read-only view of a Bayeux reply or
+// pushed message that Extension.Incoming may inspect.
+type IncomingMessage struct {
+	Channel    string
+	Data       json.RawMessage
+	Successful bool
+	Error      string
+}
+
+// Extension lets callers observe and modify every Bayeux request and reply,
+// which is how services such as Salesforce Streaming API and CometD expect
+// authentication (bearer tokens, ...) and replay IDs to be carried in the
+// `ext` field.
+type Extension interface {
+	// Outgoing is called for every outgoing request, including
+	// /meta/handshake and every /meta/connect, and may set req.Ext to
+	// inject or refresh its `ext` payload.
+	Outgoing(channel string, req *OutgoingRequest)
+	// Incoming is called for every reply the server sends back, including
+	// messages pushed outside of a direct request/reply cycle. Returning an
+	// error fails the call that produced the reply; it has no effect on
+	// pushed messages.
+	Incoming(channel string, msg IncomingMessage) error
+}
+
+// AddExtension registers ext so its Outgoing/Incoming hooks run on every
+// request and reply. Extensions run in the order they were added.
+func (c *Client) AddExtension(ext Extension) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.extensions = append(c.extensions, ext)
+}
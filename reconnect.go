@@ -0,0 +1,151 @@
+package bayeux
+
+import (
+	"log"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// defaultMinReconnectDelay and defaultMaxReconnectDelay bound the exponential
+// backoff used by reconnectWithBackoff when ReconnectPolicy leaves them
+// unset.
+const (
+	defaultMinReconnectDelay = 500 * time.Millisecond
+	defaultMaxReconnectDelay = 30 * time.Second
+
+	// maxConsecutiveConnectFailures is the number of consecutive failed
+	// /meta/connect requests (or WebSocket dials/reads) after which a
+	// transport's loop treats the session as lost and triggers a full
+	// reconnect.
+	maxConsecutiveConnectFailures = 3
+)
+
+// ReconnectPolicy controls the exponential backoff a Client uses when it has
+// to re-establish its session after a connection failure. The zero value
+// uses sensible defaults.
+type ReconnectPolicy struct {
+	MinDelay time.Duration
+	MaxDelay time.Duration
+}
+
+// delay returns the backoff to wait before reconnect attempt number attempt
+// (zero-based), as half-jittered exponential backoff: d is MinDelay doubled
+// attempt times and capped at MaxDelay, and the result is drawn from
+// [d/2, d], so it can be as short as MinDelay/2.
+func (p ReconnectPolicy) delay(attempt int) time.Duration {
+	minDelay := p.MinDelay
+	if minDelay <= 0 {
+		minDelay = defaultMinReconnectDelay
+	}
+	maxDelay := p.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = defaultMaxReconnectDelay
+	}
+
+	d := minDelay << uint(attempt)
+	if d <= 0 || d > maxDelay {
+		d = maxDelay
+	}
+
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// EventType identifies the kind of lifecycle Event reported on a Client's
+// events channel.
+type EventType int
+
+const (
+	// EventConnected fires after the first successful handshake.
+	EventConnected EventType = iota
+	// EventReconnecting fires when the client has detected a lost session
+	// and started trying to re-establish it.
+	EventReconnecting
+	// EventReconnected fires once the session has been re-established and
+	// every subscription has been replayed.
+	EventReconnected
+	// EventFailed fires when the server tells the client to give up
+	// (advice.reconnect == "none").
+	EventFailed
+)
+
+// Event is a single lifecycle notification delivered on the channel passed
+// to Client.SetEvents.
+type Event struct {
+	Type EventType
+	Err  error
+}
+
+// emit reports ev on the client's events channel, if one was configured. The
+// send never blocks, so a slow or undrained consumer cannot stall the
+// client.
+func (c *Client) emit(ev Event) {
+	c.mtx.RLock()
+	events := c.events
+	c.mtx.RUnlock()
+
+	if events == nil {
+		return
+	}
+	select {
+	case events <- ev:
+	default:
+	}
+}
+
+// isUnknownClient reports whether err is the Bayeux "402::Unknown client"
+// error a server returns once it has forgotten a clientId, e.g. after an
+// idle timeout or a server restart.
+func isUnknownClient(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "402") && strings.Contains(err.Error(), "Unknown client")
+}
+
+// reconnectWithBackoff invalidates the current session and retries
+// handshake() with exponential backoff (modelled on go-redis PubSub's
+// auto-reconnect) until it succeeds, then replays every subscription,
+// preserving its original ext payload. It is safe to call concurrently from
+// a transport's loop and from adviceLoop: only the first caller runs, since
+// both can observe the same failure and try to reconnect at once, which
+// would otherwise double the subscription replay and the reported events.
+func (c *Client) reconnectWithBackoff(cause error) {
+	if !atomic.CompareAndSwapInt32(&c.reconnecting, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&c.reconnecting, 0)
+
+	c.mtx.Lock()
+	policy := c.reconnectPolicy
+	c.clientId = ""
+	c.connected = false
+	c.mtx.Unlock()
+
+	c.emit(Event{Type: EventReconnecting, Err: cause})
+
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-c.tomb.Dying():
+			return
+		case <-time.After(policy.delay(attempt)):
+		}
+
+		if err := c.handshake(); err != nil {
+			log.Printf("[WRN] Bayeux reconnect handshake failed: %s", err)
+			continue
+		}
+		break
+	}
+
+	c.mtx.Lock()
+	c.connected = true
+	c.mtx.Unlock()
+
+	for pattern, ext := range c.subscriptionsSnapshot() {
+		if err := c.sendSubscribe(pattern, ext); err != nil {
+			log.Printf("[WRN] Bayeux resubscribe to %q failed: %s", pattern, err)
+		}
+	}
+
+	c.emit(Event{Type: EventReconnected})
+}
@@ -0,0 +1,204 @@
+package bayeux
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/obeattie/ohmyglob"
+)
+
+// Subscription represents a single subscriber to a Bayeux channel pattern.
+// It owns the channel messages are delivered on, reports a terminal error on
+// Err, and can be torn down independently of any other subscriber to the
+// same pattern.
+type Subscription struct {
+	// C delivers messages matching the subscription's pattern.
+	C chan *Message
+
+	client  *Client
+	pattern string
+
+	unsubOnce sync.Once
+	errc      chan error
+}
+
+// Err returns a channel that receives a single error if the subscription
+// terminates unexpectedly, e.g. because the client's connection was killed.
+// It is closed once Unsubscribe has run.
+func (s *Subscription) Err() <-chan error {
+	return s.errc
+}
+
+// Unsubscribe removes this subscriber from its pattern. Once the last
+// subscriber for a pattern is gone, the client also tells the server via
+// /meta/unsubscribe.
+func (s *Subscription) Unsubscribe() {
+	s.unsubOnce.Do(func() {
+		s.client.unsubscribe(s)
+		close(s.errc)
+	})
+}
+
+func (s *Subscription) fail(err error) {
+	select {
+	case s.errc <- err:
+	default:
+	}
+}
+
+// subscriptionSet groups every local Subscription registered for the same
+// glob pattern. The Bayeux server only ever sees one /meta/subscribe for a
+// given pattern; additional local listeners are multiplexed over it.
+type subscriptionSet struct {
+	glob ohmyglob.Glob
+	ext  interface{}
+	subs []*Subscription
+}
+
+// Subscribe is like SubscribeExt with a blank `ext` part.
+func (c *Client) Subscribe(pattern string) (*Subscription, error) {
+	return c.SubscribeExt(pattern, nil)
+}
+
+// SubscribeExt returns a Subscription delivering messages for pattern. If
+// the client has not performed a handshake already, it will do so first. If
+// another Subscription is already registered for the same pattern, the new
+// one is multiplexed over the existing server-side subscription and ext is
+// ignored.
+func (c *Client) SubscribeExt(pattern string, ext interface{}) (*Subscription, error) {
+	if err := c.ensureConnected(); err != nil {
+		return nil, err
+	}
+	return c.subscribe(pattern, ext)
+}
+
+func (c *Client) subscribe(pattern string, ext interface{}) (*Subscription, error) {
+	glob, err := ohmyglob.Compile(pattern, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid pattern: %s", err)
+	}
+	key := glob.String()
+
+	c.mtx.RLock()
+	_, exists := c.subscriptions[key]
+	c.mtx.RUnlock()
+
+	if !exists {
+		if err := c.sendSubscribe(pattern, ext); err != nil {
+			return nil, err
+		}
+	}
+
+	sub := &Subscription{
+		C:       make(chan *Message, 16),
+		client:  c,
+		pattern: key,
+		errc:    make(chan error, 1),
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	set, exists := c.subscriptions[key]
+	if !exists {
+		set = &subscriptionSet{glob: glob, ext: ext}
+		c.subscriptions[key] = set
+	}
+	set.subs = append(set.subs, sub)
+
+	return sub, nil
+}
+
+// sendSubscribe issues /meta/subscribe for pattern without touching any
+// local bookkeeping. It is used both for a pattern's first subscriber and to
+// replay the subscription after a reconnect.
+func (c *Client) sendSubscribe(pattern string, ext interface{}) error {
+	rsp, err := c.send(&request{
+		Channel:      "/meta/subscribe",
+		ClientId:     c.clientID(),
+		Subscription: pattern,
+		Extension:    ext,
+	})
+	if err != nil {
+		return err
+	}
+	if !rsp.Successful {
+		return errors.New(rsp.Error)
+	}
+	return nil
+}
+
+// unsubscribe removes sub from its pattern's subscriber list. Once the last
+// subscriber for the pattern is gone, /meta/unsubscribe is sent and the
+// pattern is forgotten entirely.
+func (c *Client) unsubscribe(sub *Subscription) {
+	c.mtx.Lock()
+	set, ok := c.subscriptions[sub.pattern]
+	if !ok {
+		c.mtx.Unlock()
+		return
+	}
+
+	remaining := set.subs[:0]
+	for _, s := range set.subs {
+		if s != sub {
+			remaining = append(remaining, s)
+		}
+	}
+	set.subs = remaining
+
+	last := len(set.subs) == 0
+	if last {
+		delete(c.subscriptions, sub.pattern)
+	}
+	c.mtx.Unlock()
+
+	if last {
+		if _, err := c.send(&request{
+			Channel:      "/meta/unsubscribe",
+			ClientId:     c.clientID(),
+			Subscription: sub.pattern,
+		}); err != nil {
+			log.Printf("[WRN] Bayeux unsubscribe from %q failed: %s", sub.pattern, err)
+		}
+	}
+}
+
+// dispatch fans msg out to every Subscription whose pattern matches.
+func (c *Client) dispatch(msg *Message) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	for _, set := range c.subscriptions {
+		if !set.glob.MatchString(msg.Channel) {
+			continue
+		}
+		for _, sub := range set.subs {
+			sub.C <- msg
+		}
+	}
+}
+
+// failAll notifies every current Subscription's Err channel, e.g. because
+// the client's tomb died.
+func (c *Client) failAll(err error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	for _, set := range c.subscriptions {
+		for _, sub := range set.subs {
+			sub.fail(err)
+		}
+	}
+}
+
+// subscriptionsSnapshot returns, for every currently registered pattern, the
+// ext it was originally subscribed with.
+func (c *Client) subscriptionsSnapshot() map[string]interface{} {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	patterns := make(map[string]interface{}, len(c.subscriptions))
+	for pattern, set := range c.subscriptions {
+		patterns[pattern] = set.ext
+	}
+	return patterns
+}
@@ -0,0 +1,90 @@
+package bayeux
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a Client wired to a fakeTransport that acks every
+// handshake/connect/subscribe/unsubscribe/disconnect request, and a counter
+// of how many /meta/unsubscribe requests have been sent.
+func newTestClient(t *testing.T) (*Client, *fakeTransport, *int32) {
+	t.Helper()
+
+	var unsubscribes int32
+	transport := newFakeTransport(func(reqs []*request) []metaMessage {
+		replies := make([]metaMessage, len(reqs))
+		for i, req := range reqs {
+			if req.Channel == "/meta/unsubscribe" {
+				atomic.AddInt32(&unsubscribes, 1)
+			}
+			replies[i] = metaReply(req)
+		}
+		return replies
+	})
+
+	c := NewClient("http://test.invalid/bayeux", nil, transport)
+	t.Cleanup(func() { c.Close() })
+	return c, transport, &unsubscribes
+}
+
+func TestSubscriptionFanOutToMultipleListeners(t *testing.T) {
+	c, _, unsubscribes := newTestClient(t)
+
+	sub1, err := c.Subscribe("/foo/*")
+	if err != nil {
+		t.Fatalf("Subscribe sub1: %s", err)
+	}
+	sub2, err := c.Subscribe("/foo/*")
+	if err != nil {
+		t.Fatalf("Subscribe sub2: %s", err)
+	}
+
+	c.dispatch(&Message{Channel: "/foo/bar"})
+
+	select {
+	case <-sub1.C:
+	case <-time.After(time.Second):
+		t.Fatal("sub1 did not receive dispatched message")
+	}
+	select {
+	case <-sub2.C:
+	case <-time.After(time.Second):
+		t.Fatal("sub2 did not receive dispatched message")
+	}
+
+	if got := atomic.LoadInt32(unsubscribes); got != 0 {
+		t.Fatalf("unexpected /meta/unsubscribe before either listener unsubscribed: %d", got)
+	}
+}
+
+func TestUnsubscribeOnlySentOnceLastListenerDrops(t *testing.T) {
+	c, _, unsubscribes := newTestClient(t)
+
+	sub1, err := c.Subscribe("/foo/*")
+	if err != nil {
+		t.Fatalf("Subscribe sub1: %s", err)
+	}
+	sub2, err := c.Subscribe("/foo/*")
+	if err != nil {
+		t.Fatalf("Subscribe sub2: %s", err)
+	}
+
+	sub1.Unsubscribe()
+	if got := atomic.LoadInt32(unsubscribes); got != 0 {
+		t.Fatalf("/meta/unsubscribe sent while sub2 is still listening: %d", got)
+	}
+
+	sub2.Unsubscribe()
+	if got := atomic.LoadInt32(unsubscribes); got != 1 {
+		t.Fatalf("expected exactly one /meta/unsubscribe once the last listener dropped, got %d", got)
+	}
+
+	c.mtx.RLock()
+	remaining := len(c.subscriptions)
+	c.mtx.RUnlock()
+	if remaining != 0 {
+		t.Fatalf("pattern should have been forgotten once its last listener unsubscribed, got %d left", remaining)
+	}
+}
@@ -0,0 +1,122 @@
+package bayeux
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Transport is the connection mechanism a Client uses to exchange Bayeux
+// messages with the server. The Bayeux protocol defines several connection
+// types; this package ships long-polling (the historical default) and
+// WebSocket.
+type Transport interface {
+	// connectionType is the Bayeux connectionType this transport advertises
+	// during handshake, e.g. "long-polling" or "websocket".
+	connectionType() string
+
+	// send performs the underlying round trip for a batch of requests and
+	// returns the parsed replies. ctx bounds the round trip; a transport
+	// should honour its deadline (e.g. as advised by the server for
+	// /meta/connect) where the underlying mechanism allows it.
+	send(ctx context.Context, reqs []*request) ([]metaMessage, error)
+
+	// loop drives the transport's receive cycle until the client's tomb is
+	// killed. It runs in its own goroutine, started by worker(), and is
+	// responsible for pushing any out-of-band server messages onto
+	// c.messages.
+	loop(c *Client) error
+
+	// close tears down any underlying connection held by the transport.
+	close() error
+}
+
+// LongPollingTransport implements Transport on top of repeated HTTP POSTs, as
+// described by the Bayeux "long-polling" connection type.
+type LongPollingTransport struct {
+	url  string
+	http *http.Client
+}
+
+// NewLongPollingTransport returns a Transport that polls url with httpClient.
+// If httpClient is nil, http.DefaultClient is used.
+func NewLongPollingTransport(url string, httpClient *http.Client) *LongPollingTransport {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &LongPollingTransport{url: url, http: httpClient}
+}
+
+func (t *LongPollingTransport) connectionType() string {
+	return "long-polling"
+}
+
+func (t *LongPollingTransport) send(ctx context.Context, reqs []*request) ([]metaMessage, error) {
+	data, err := json.Marshal(reqs)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequest("POST", t.url, bytes.NewBuffer(data))
+	if err != nil {
+		return nil, err
+	}
+	httpReq = httpReq.WithContext(ctx)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	rsp, err := t.http.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != 200 {
+		return nil, fmt.Errorf("HTTP Status %d", rsp.StatusCode)
+	}
+
+	data, err = ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []metaMessage
+	if err = json.Unmarshal(data, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// loop periodically re-issues /meta/connect, which is how a long-polling
+// client waits for server push in between requests of its own. A connect
+// that keeps failing, or a "402::Unknown client" error, means the server has
+// forgotten the session, so the client re-establishes it from scratch.
+func (t *LongPollingTransport) loop(c *Client) error {
+	var failures int
+	for {
+		select {
+		case <-c.tomb.Dying():
+			return nil
+		case <-time.After(c.pollInterval()):
+			_, err := c.connect()
+			if err == nil {
+				failures = 0
+				continue
+			}
+
+			log.Printf("[WRN] Bayeux connect failed: %s", err)
+			failures++
+			if failures >= maxConsecutiveConnectFailures || isUnknownClient(err) {
+				failures = 0
+				c.reconnectWithBackoff(err)
+			}
+		}
+	}
+}
+
+func (t *LongPollingTransport) close() error {
+	return nil
+}
@@ -0,0 +1,64 @@
+package bayeux
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// fakeTransport is a Transport whose send() is driven entirely by a
+// caller-supplied function, so tests can exercise Client without any real
+// HTTP or WebSocket round trip. loop() just blocks until the client's tomb
+// is killed, like a transport with nothing to push.
+type fakeTransport struct {
+	sendsMu sync.Mutex
+	sends   int
+	sendFn  func(reqs []*request) []metaMessage
+}
+
+func newFakeTransport(sendFn func(reqs []*request) []metaMessage) *fakeTransport {
+	return &fakeTransport{sendFn: sendFn}
+}
+
+func (t *fakeTransport) connectionType() string { return "fake" }
+
+func (t *fakeTransport) send(ctx context.Context, reqs []*request) ([]metaMessage, error) {
+	t.sendsMu.Lock()
+	t.sends++
+	t.sendsMu.Unlock()
+	return t.sendFn(reqs), nil
+}
+
+func (t *fakeTransport) loop(c *Client) error {
+	<-c.tomb.Dying()
+	return nil
+}
+
+func (t *fakeTransport) close() error { return nil }
+
+func (t *fakeTransport) callCount() int {
+	t.sendsMu.Lock()
+	defer t.sendsMu.Unlock()
+	return t.sends
+}
+
+// metaReply builds a successful metaMessage reply to req, on the same
+// channel and id, suitable for a fakeTransport's sendFn.
+func metaReply(req *request) metaMessage {
+	return metaMessage{
+		Message: Message{
+			Channel:  req.Channel,
+			Id:       req.Id,
+			ClientId: req.ClientId,
+		},
+		Successful: true,
+	}
+}
+
+func marshal(v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
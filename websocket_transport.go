@@ -0,0 +1,235 @@
+package bayeux
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocketTransport implements Transport on top of a single persistent
+// WebSocket connection, which avoids the per-message HTTP overhead of
+// long-polling and lets the server push messages as soon as they happen.
+//
+// gorilla/websocket connections support only one concurrent reader, so loop
+// is the sole place that ever calls conn.ReadJSON; send writes its batch and
+// then waits for loop to demux the matching replies back to it by id.
+type WebSocketTransport struct {
+	url string
+
+	mtx     sync.Mutex
+	conn    *websocket.Conn
+	pending map[string]chan wsReply
+}
+
+// wsReply is how loop delivers a reply (or a fatal connection error) back to
+// the send call waiting on it.
+type wsReply struct {
+	msg metaMessage
+	err error
+}
+
+// NewWebSocketTransport returns a Transport that dials rawURL over
+// WebSocket. rawURL's scheme ("http"/"https") is translated to "ws"/"wss" if
+// necessary.
+func NewWebSocketTransport(rawURL string) (*WebSocketTransport, error) {
+	wsURL, err := toWebSocketURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &WebSocketTransport{url: wsURL, pending: make(map[string]chan wsReply)}, nil
+}
+
+func toWebSocketURL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	}
+	return u.String(), nil
+}
+
+func (t *WebSocketTransport) connectionType() string {
+	return "websocket"
+}
+
+func (t *WebSocketTransport) dial() (*websocket.Conn, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.conn != nil {
+		return t.conn, nil
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(t.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	t.conn = conn
+	return conn, nil
+}
+
+// send writes reqs as a single frame and waits for loop to hand back each
+// request's reply, matched by id. It never reads from conn itself.
+func (t *WebSocketTransport) send(ctx context.Context, reqs []*request) ([]metaMessage, error) {
+	conn, err := t.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	replyCh := make(chan wsReply, len(reqs))
+	t.mtx.Lock()
+	for _, req := range reqs {
+		t.pending[req.Id] = replyCh
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetWriteDeadline(deadline)
+	}
+	err = conn.WriteJSON(reqs)
+	t.mtx.Unlock()
+	if err != nil {
+		t.clearPending(reqs)
+		return nil, err
+	}
+
+	messages := make([]metaMessage, 0, len(reqs))
+	for len(messages) < len(reqs) {
+		select {
+		case r := <-replyCh:
+			if r.err != nil {
+				t.clearPending(reqs)
+				return nil, r.err
+			}
+			messages = append(messages, r.msg)
+		case <-ctx.Done():
+			t.clearPending(reqs)
+			return nil, ctx.Err()
+		}
+	}
+	return messages, nil
+}
+
+func (t *WebSocketTransport) clearPending(reqs []*request) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	for _, req := range reqs {
+		delete(t.pending, req.Id)
+	}
+}
+
+// failPending delivers err to every outstanding send call and forgets them,
+// e.g. because the underlying connection just died.
+func (t *WebSocketTransport) failPending(err error) {
+	t.mtx.Lock()
+	pending := t.pending
+	t.pending = make(map[string]chan wsReply)
+	t.mtx.Unlock()
+
+	for _, ch := range pending {
+		select {
+		case ch <- wsReply{err: err}:
+		default:
+		}
+	}
+}
+
+// loop owns the WebSocket connection's only reader: every message it reads,
+// whether it's a reply to a pending send or a message the server pushed on
+// its own, is demultiplexed here by id, redialing if the connection drops.
+// A connection that keeps failing to dial or read means the server has
+// forgotten the session, so the client re-establishes it from scratch.
+func (t *WebSocketTransport) loop(c *Client) error {
+	var failures int
+	for {
+		conn, err := t.dial()
+		if err != nil {
+			log.Printf("[WRN] Bayeux websocket dial failed: %s", err)
+			failures++
+			if failures >= maxConsecutiveConnectFailures {
+				failures = 0
+				c.reconnectWithBackoff(err)
+			}
+			select {
+			case <-c.tomb.Dying():
+				return nil
+			case <-time.After(c.pollInterval()):
+				continue
+			}
+		}
+
+		var messages []metaMessage
+		err = conn.ReadJSON(&messages)
+
+		select {
+		case <-c.tomb.Dying():
+			return nil
+		default:
+		}
+
+		if err != nil {
+			log.Printf("[WRN] Bayeux websocket read failed: %s", err)
+			t.mtx.Lock()
+			t.conn = nil
+			t.mtx.Unlock()
+			t.failPending(err)
+			failures++
+			if failures >= maxConsecutiveConnectFailures || isUnknownClient(err) {
+				failures = 0
+				c.reconnectWithBackoff(err)
+			}
+			continue
+		}
+
+		failures = 0
+		for i := range messages {
+			msg := &messages[i]
+
+			t.mtx.Lock()
+			replyCh, isReply := t.pending[msg.Id]
+			if isReply {
+				delete(t.pending, msg.Id)
+			}
+			t.mtx.Unlock()
+
+			if isReply {
+				replyCh <- wsReply{msg: *msg}
+			} else {
+				c.mtx.RLock()
+				extensions := c.extensions
+				c.mtx.RUnlock()
+				in := IncomingMessage{
+					Channel:    msg.Channel,
+					Data:       msg.Data,
+					Successful: msg.Successful,
+					Error:      msg.Error,
+				}
+				for _, ext := range extensions {
+					if err := ext.Incoming(msg.Channel, in); err != nil {
+						log.Printf("[WRN] Bayeux extension rejected message on %q: %s", msg.Channel, err)
+					}
+				}
+				c.messages <- &msg.Message
+			}
+		}
+	}
+}
+
+func (t *WebSocketTransport) close() error {
+	t.failPending(errors.New("bayeux: websocket transport closed"))
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}